@@ -12,7 +12,9 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"code.gitea.io/gitea/modules/httplib"
@@ -30,6 +32,18 @@ const (
 	headerContentLength = "Content-Length"
 )
 
+// uploadChunkSize bounds how much of the pktline upload stream is buffered in
+// memory at a time while it is piped through to the internal HTTP PUT.
+const uploadChunkSize = 32 * 1024
+
+// minUploadTimeout is the floor applied to the size-scaled upload timeout, so
+// small objects aren't penalized by the per-byte allowance below it.
+const minUploadTimeout = 60 * time.Second
+
+// uploadBytesPerSecond is the assumed worst-case throughput used to scale the
+// upload timeout with object size; it is intentionally conservative.
+const uploadBytesPerSecond = 1 * 1024 * 1024
+
 // MIME types
 const (
 	mimeGitLFS      = "application/vnd.git-lfs+json"
@@ -43,6 +57,15 @@ const (
 	argRefname   = "refname"
 	argToken     = "token"
 	argTransfer  = "transfer"
+	argParts     = "parts"
+	argVerifyID  = "verify-id"
+)
+
+// LFS custom-transfer adapter names, as negotiated via the "transfer" SSH arg
+// and advertised in Capabilities.
+const (
+	transferBasic          = "basic"
+	transferMultipartBasic = "multipart-basic"
 )
 
 // Operations enum
@@ -67,7 +90,8 @@ const Version = "1"
 // Capabilities is a list of Git LFS capabilities supported by this package.
 var Capabilities = []string{
 	"version=" + Version,
-	// "locking", // no support yet in gitea backend
+	"locking",
+	"transfer=" + transferBasic + "," + transferMultipartBasic,
 }
 
 var _ transfer.Backend = &GiteaBackend{}
@@ -78,12 +102,32 @@ type GiteaBackend struct {
 	server string
 	op     string
 	token  string
+	logger transfer.Logger
+
+	verifiedMu sync.Mutex
+	verified   map[string]struct{}
 }
 
-func New(ctx context.Context, token string, repo string, op string) transfer.Backend {
+func New(ctx context.Context, repo string, op string, token string, logger transfer.Logger) (transfer.Backend, error) {
 	// runServ guarantees repo will be in form [owner]/[name].git
 	server := setting.LocalURL + "/" + repo + "/info/lfs"
-	return &GiteaBackend{ctx: ctx, server: server, op: op, token: token}
+	return &GiteaBackend{ctx: ctx, server: server, op: op, token: token, logger: logger, verified: make(map[string]struct{})}, nil
+}
+
+// markVerified records that oid's content has already been hashed and
+// confirmed server-side during Upload, so a subsequent Verify call can skip
+// its HTTP round-trip.
+func (g *GiteaBackend) markVerified(oid string) {
+	g.verifiedMu.Lock()
+	defer g.verifiedMu.Unlock()
+	g.verified[oid] = struct{}{}
+}
+
+func (g *GiteaBackend) isVerified(oid string) bool {
+	g.verifiedMu.Lock()
+	defer g.verifiedMu.Unlock()
+	_, ok := g.verified[oid]
+	return ok
 }
 
 // Batch implements transfer.Backend
@@ -111,7 +155,7 @@ func (g *GiteaBackend) Batch(_ string, pointers []transfer.BatchItem, args trans
 		headerAccept:        mimeGitLFS,
 		headerContentType:   mimeGitLFS,
 	}
-	req := newInternalRequest(g.ctx, url, http.MethodPost, headers, bodyBytes)
+	req := newInternalRequest(g.ctx, url, http.MethodPost, headers, bytes.NewReader(bodyBytes))
 	resp, err := req.Response()
 	if err != nil {
 		return nil, err
@@ -151,6 +195,12 @@ func (g *GiteaBackend) Batch(_ string, pointers []transfer.BatchItem, args trans
 				item.Args[argID] = action.Href
 				item.Args[argToken] = action.Header[headerAuthorisation]
 				item.Args[argExpiresAt] = action.ExpiresAt.String()
+				if parts, ok := action.Header[argParts]; ok {
+					item.Args[argParts] = parts
+				}
+				if verify, ok := obj.Actions["verify"]; ok {
+					item.Args[argVerifyID] = verify.Href
+				}
 			} else {
 				item.Present = true
 			}
@@ -160,8 +210,9 @@ func (g *GiteaBackend) Batch(_ string, pointers []transfer.BatchItem, args trans
 	return pointers, nil
 }
 
-// Download implements transfer.Backend. The returned reader must be closed by the
-// caller.
+// Download implements transfer.Backend. The returned reader streams directly
+// off the internal HTTP response and must be closed by the caller; the
+// object is never buffered in full on the SSH host.
 func (g *GiteaBackend) Download(oid string, args transfer.Args) (io.ReadCloser, int64, error) {
 	url, exists := args[argID]
 	if !exists {
@@ -177,46 +228,94 @@ func (g *GiteaBackend) Download(oid string, args transfer.Args) (io.ReadCloser,
 		return nil, 0, err
 	}
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		return nil, 0, statusCodeToErr(resp.StatusCode)
 	}
-	defer resp.Body.Close()
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, 0, err
-	}
-	respSize := int64(len(respBytes))
-	respBuf := io.NopCloser(bytes.NewBuffer(respBytes))
-	return respBuf, respSize, nil
+	return resp.Body, resp.ContentLength, nil
 }
 
-// StartUpload implements transfer.Backend.
+// Upload implements transfer.Backend. The pktline stream is piped
+// through to the internal HTTP PUT in uploadChunkSize chunks rather than
+// being read into memory up front, so a single large object doesn't pin
+// gigabytes of RAM per concurrent transfer.
 func (g *GiteaBackend) Upload(oid string, size int64, r io.Reader, args transfer.Args) error {
 	url, exists := args[argID]
 	if !exists {
 		return ErrMissingID
 	}
+	if maxSize := setting.LFS.MaxFileSize; maxSize > 0 && size > maxSize {
+		return fmt.Errorf("%w: object %s size %d exceeds maximum of %d", transfer.ErrParseError, oid, size, maxSize)
+	}
+	if partsJSON, ok := args[argParts]; ok {
+		return g.multipartUpload(oid, size, r, partsJSON, args[argVerifyID])
+	}
 	headers := map[string]string{
 		headerAuthorisation: g.token,
 		headerContentType:   mimeOctetStream,
 		headerContentLength: strconv.FormatInt(size, 10),
 	}
-	reqBytes, err := io.ReadAll(r)
-	if err != nil {
-		return err
-	}
-	req := newInternalRequest(g.ctx, url, http.MethodPut, headers, reqBytes)
+
+	ctx, cancel := context.WithCancel(g.ctx)
+	defer cancel()
+
+	hashed := newHashingReader(r, oid, size)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, copyErr := io.CopyBuffer(pw, hashed, make([]byte, uploadChunkSize))
+		if copyErr == nil {
+			copyErr = hashed.verify()
+		}
+		if copyErr != nil {
+			// Stop the in-flight PUT so a corrupted object is never
+			// committed to storage.
+			cancel()
+		}
+		pw.CloseWithError(copyErr)
+	}()
+
+	req := newInternalRequest(ctx, url, http.MethodPut, headers, pr).
+		SetTimeout(10*time.Second, uploadTimeout(size))
 	resp, err := req.Response()
+	// The server can respond as soon as it has read the declared
+	// Content-Length bytes, which can race ahead of the copy goroutine
+	// finishing hashed.verify() above. Wait for it and consult the result
+	// unconditionally - a 200 response is never enough on its own.
+	<-done
+	if hashErr := hashed.verify(); hashErr != nil {
+		return hashErr
+	}
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return statusCodeToErr(resp.StatusCode)
 	}
+	g.markVerified(oid)
 	return nil
 }
 
-// Verify implements transfer.Backend.
+// uploadTimeout scales the read/write deadline with the object size instead
+// of relying on the fixed deadline being long enough for every push.
+func uploadTimeout(size int64) time.Duration {
+	scaled := time.Duration(size/uploadBytesPerSecond) * time.Second
+	if scaled < minUploadTimeout {
+		return minUploadTimeout
+	}
+	return scaled
+}
+
+// Verify implements transfer.Backend. If oid was already hashed and
+// confirmed good during Upload in this session, the HTTP round-trip is
+// skipped entirely.
 func (g *GiteaBackend) Verify(oid string, size int64, args transfer.Args) (transfer.Status, error) {
+	if g.isVerified(oid) {
+		return transfer.SuccessStatus(), nil
+	}
+
 	reqBody := lfs.Pointer{Oid: oid, Size: size}
 
 	bodyBytes, err := json.Marshal(reqBody)
@@ -232,7 +331,7 @@ func (g *GiteaBackend) Verify(oid string, size int64, args transfer.Args) (trans
 		headerAccept:        mimeGitLFS,
 		headerContentType:   mimeGitLFS,
 	}
-	req := newInternalRequest(g.ctx, url, http.MethodPost, headers, bodyBytes)
+	req := newInternalRequest(g.ctx, url, http.MethodPost, headers, bytes.NewReader(bodyBytes))
 	resp, err := req.Response()
 	if err != nil {
 		return transfer.NewStatus(transfer.StatusInternalServerError), err
@@ -243,21 +342,15 @@ func (g *GiteaBackend) Verify(oid string, size int64, args transfer.Args) (trans
 	return transfer.SuccessStatus(), nil
 }
 
-// LockBackend implements transfer.Backend.
-func (g *GiteaBackend) LockBackend(_ transfer.Args) transfer.LockBackend {
-	// Gitea doesn't support the locking API
-	// this should never be called as we don't advertise the capability
-	return (transfer.LockBackend)(nil)
-}
-
-func newInternalRequest(ctx context.Context, url, method string, headers map[string]string, body []byte) *httplib.Request {
-	req := httplib.NewRequest(url, method).
+func newInternalRequest(ctx context.Context, rawURL, method string, headers map[string]string, body io.Reader) *httplib.Request {
+	req := httplib.NewRequest(rawURL, method).
 		SetContext(ctx).
-		SetTimeout(10*time.Second, 60*time.Second).
-		SetTLSClientConfig(&tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         setting.Domain,
-		})
+		SetTimeout(10*time.Second, 60*time.Second)
+
+	tlsConfig := tlsConfigFor(rawURL)
+	if tlsConfig != nil {
+		req.SetTLSClientConfig(tlsConfig)
+	}
 
 	if setting.Protocol == setting.HTTPUnix {
 		req.SetTransport(&http.Transport{
@@ -278,6 +371,7 @@ func newInternalRequest(ctx context.Context, url, method string, headers map[str
 		})
 	} else if setting.LocalUseProxyProtocol {
 		req.SetTransport(&http.Transport{
+			TLSClientConfig: tlsConfig,
 			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
 				var d net.Dialer
 				conn, err := d.DialContext(ctx, network, address)
@@ -302,6 +396,52 @@ func newInternalRequest(ctx context.Context, url, method string, headers map[str
 	return req
 }
 
+// tlsConfigFor returns the TLS config newInternalRequest should use for
+// rawURL, or nil for the unix-socket and loopback cases. Returning nil never
+// disables certificate validation: the unix socket and an http:// loopback
+// URL speak no TLS at all, while an https:// loopback URL is sent as is, over
+// TLS, with no InsecureSkipVerify applied; the URL is not rewritten to plain
+// http. Anything else - e.g. LocalURL pointed at a reverse proxy in
+// an HA setup - validates against the system cert pool unless the operator
+// has explicitly opted out.
+func tlsConfigFor(rawURL string) *tls.Config {
+	if setting.Protocol == setting.HTTPUnix || isLoopbackURL(rawURL) {
+		return nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: setting.LFSClient.InsecureSkipVerify,
+	}
+	if host := hostnameOf(rawURL); host != "" {
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig
+}
+
+// isLoopbackURL reports whether rawURL's host is the local machine, in
+// which case the internal request never needs to leave it and
+// newInternalRequest applies no TLS config of its own to it.
+func isLoopbackURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	return net.ParseIP(host).IsLoopback()
+}
+
+// hostnameOf returns rawURL's host, for use as the TLS ServerName when
+// validating a non-loopback LocalURL against the system cert pool.
+func hostnameOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
 func statusCodeToErr(code int) error {
 	switch code {
 	case http.StatusBadRequest:
@@ -0,0 +1,126 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/modules/httplib"
+	"code.gitea.io/gitea/modules/lfstransfer/transfer"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsLoopbackURL(t *testing.T) {
+	cases := []struct {
+		url  string
+		want bool
+	}{
+		{"http://localhost:3000/info/lfs", true},
+		{"https://localhost/info/lfs", true},
+		{"http://127.0.0.1:3000/info/lfs", true},
+		{"http://[::1]:3000/info/lfs", true},
+		{"https://gitea-internal.example.com/info/lfs", false},
+		{"https://192.168.1.10:3000/info/lfs", false},
+		{"://not-a-url", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, isLoopbackURL(c.url), c.url)
+	}
+}
+
+func TestHostnameOf(t *testing.T) {
+	assert.Equal(t, "gitea-internal.example.com", hostnameOf("https://gitea-internal.example.com:8443/info/lfs"))
+	assert.Equal(t, "127.0.0.1", hostnameOf("http://127.0.0.1:3000/info/lfs"))
+	assert.Equal(t, "", hostnameOf("://not-a-url"))
+}
+
+// TestExternalLocalURLRejectsUnverifiedCert is a regression test for the TLS
+// posture newInternalRequest actually applies to a non-loopback LocalURL: it
+// drives newInternalRequest and Response() themselves - the exact path
+// tlsConfigFor feeds - rather than a hand-built http.Client that would pass
+// even if tlsConfigFor were deleted. Without the explicit
+// setting.LFSClient.InsecureSkipVerify opt-in, a self-signed or otherwise
+// untrusted certificate must fail verification rather than being silently
+// accepted, the way InsecureSkipVerify used to do unconditionally.
+func TestExternalLocalURLRejectsUnverifiedCert(t *testing.T) {
+	const externalURL = "https://gitea-internal.example.test/info/lfs"
+	require.False(t, isLoopbackURL(externalURL), "test relies on this host not being treated as loopback")
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// Redial whatever host the request asks for onto the local TLS test
+	// server, so newInternalRequest believes it's talking to externalURL's
+	// host while actually exercising the self-signed cert it presents. The
+	// TLSClientConfig carried onto the override transport is tlsConfigFor's
+	// own output, not a hand-rolled one. Because the transport is replaced,
+	// newInternalRequest's own SetTLSClientConfig call is not what is
+	// exercised here.
+	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	newRequest := func() *httplib.Request {
+		req := newInternalRequest(context.Background(), externalURL, http.MethodGet, nil, nil)
+		req.SetTransport(&http.Transport{DialContext: dial, TLSClientConfig: tlsConfigFor(externalURL)})
+		return req
+	}
+
+	origInsecure := setting.LFSClient.InsecureSkipVerify
+	defer func() { setting.LFSClient.InsecureSkipVerify = origInsecure }()
+
+	setting.LFSClient.InsecureSkipVerify = false
+	_, err := newRequest().Response()
+	require.Error(t, err, "an untrusted external certificate must be rejected when InsecureSkipVerify is left at its default of false")
+
+	setting.LFSClient.InsecureSkipVerify = true
+	resp, err := newRequest().Response()
+	require.NoError(t, err, "the explicit InsecureSkipVerify opt-in should still allow the connection")
+	resp.Body.Close()
+}
+
+// TestUpload_RejectsCorruptObject is a regression test for the non-multipart
+// Upload path declaring success before hashed.verify() is actually
+// consulted: a real HTTP server can respond 200 as soon as it has read the
+// declared Content-Length bytes, racing ahead of the client-side goroutine
+// that still needs to finish hashing. Upload must wait for that goroutine
+// and consult its result before ever calling markVerified, regardless of
+// what the response said.
+func TestUpload_RejectsCorruptObject(t *testing.T) {
+	const size = 4096
+	object := make([]byte, size)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+	wrongOid := sha256Hex(make([]byte, size)) // does not match object's real hash
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		// Respond as soon as the declared Content-Length bytes have been
+		// read, without waiting for the body to reach EOF - the same race
+		// Gitea's real internal PUT handler exposes.
+		buf := make([]byte, size)
+		_, err := io.ReadFull(r.Body, buf)
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := &GiteaBackend{ctx: context.Background(), token: "test-token", verified: make(map[string]struct{})}
+	err = g.Upload(wrongOid, int64(size), bytes.NewReader(object), transfer.Args{argID: srv.URL + "/upload"})
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.False(t, g.isVerified(wrongOid), "a corrupt object must never be marked verified")
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"code.gitea.io/gitea/modules/lfstransfer/transfer"
+)
+
+// ErrCorrupt is returned when an uploaded object's bytes don't match its
+// advertised oid or size.
+var ErrCorrupt = fmt.Errorf("%w: object hash or size mismatch", transfer.ErrParseError)
+
+// hashingReader wraps an upload's source reader with a SHA-256 hash and byte
+// counter, so the object can be checked against its expected oid/size as it
+// streams through to storage, without a second read pass.
+//
+// Read and verify run on different goroutines - Read on the goroutine
+// copying the pktline stream into the upload pipe, verify on the one waiting
+// on the HTTP response - so h and n are guarded by mu on both sides, and
+// verify is safe to call whether or not the copy has finished.
+type hashingReader struct {
+	r        io.Reader
+	wantOid  string
+	wantSize int64
+
+	mu       sync.Mutex
+	h        hash.Hash
+	n        int64
+	checked  bool
+	checkErr error
+}
+
+func newHashingReader(r io.Reader, oid string, size int64) *hashingReader {
+	return &hashingReader{r: r, wantOid: oid, wantSize: size, h: sha256.New()}
+}
+
+// Read implements io.Reader, feeding every byte read through the hasher and
+// counter before handing it to the caller.
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		h.mu.Lock()
+		h.h.Write(p[:n])
+		h.n += int64(n)
+		h.mu.Unlock()
+	}
+	return n, err
+}
+
+// verify compares the bytes seen so far against the expected oid and size.
+// It is safe to call more than once, and safe to call concurrently with a
+// still-running Read; the result of the first call is cached.
+func (h *hashingReader) verify() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.checked {
+		return h.checkErr
+	}
+	h.checked = true
+
+	got := hex.EncodeToString(h.h.Sum(nil))
+	if got != h.wantOid || h.n != h.wantSize {
+		h.checkErr = fmt.Errorf("%w: got oid %s size %d, want oid %s size %d", ErrCorrupt, got, h.n, h.wantOid, h.wantSize)
+	}
+	return h.checkErr
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashingReader_VerifyAcceptsGoodUpload(t *testing.T) {
+	object := make([]byte, 64*1024)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+	sum := sha256.Sum256(object)
+	oid := hex.EncodeToString(sum[:])
+
+	h := newHashingReader(bytes.NewReader(object), oid, int64(len(object)))
+	_, err = io.Copy(io.Discard, h)
+	require.NoError(t, err)
+	assert.NoError(t, h.verify())
+}
+
+func TestHashingReader_VerifyRejectsTamperedBytes(t *testing.T) {
+	object := make([]byte, 64*1024)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+	sum := sha256.Sum256(object)
+	oid := hex.EncodeToString(sum[:])
+
+	tampered := append([]byte(nil), object...)
+	tampered[0] ^= 0xff
+
+	h := newHashingReader(bytes.NewReader(tampered), oid, int64(len(tampered)))
+	_, err = io.Copy(io.Discard, h)
+	require.NoError(t, err)
+	assert.ErrorIs(t, h.verify(), ErrCorrupt)
+}
+
+func TestHashingReader_VerifyRejectsTruncatedUpload(t *testing.T) {
+	object := make([]byte, 64*1024)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+	sum := sha256.Sum256(object)
+	oid := hex.EncodeToString(sum[:])
+
+	h := newHashingReader(bytes.NewReader(object[:len(object)-1]), oid, int64(len(object)))
+	_, err = io.Copy(io.Discard, h)
+	require.NoError(t, err)
+	assert.ErrorIs(t, h.verify(), ErrCorrupt)
+}
+
+// TestHashingReader_VerifyDuringConcurrentRead is a regression test for a
+// data race between Read, running on one goroutine, and verify, called from
+// another while that Read may still be in progress - which verify documents
+// as safe. It only asserts the race detector stays quiet; run with -race.
+func TestHashingReader_VerifyDuringConcurrentRead(t *testing.T) {
+	object := make([]byte, 8*1024*1024)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+	sum := sha256.Sum256(object)
+	oid := hex.EncodeToString(sum[:])
+
+	h := newHashingReader(bytes.NewReader(object), oid, int64(len(object)))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 4096)
+		for {
+			if _, err := h.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Simulate the PUT failing before the copy goroutine above has drained
+	// the reader.
+	_ = h.verify()
+	wg.Wait()
+}
+
+func BenchmarkHashingReader(b *testing.B) {
+	object := make([]byte, 1024*1024)
+	_, err := rand.Read(object)
+	require.NoError(b, err)
+	buf := make([]byte, 32*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(object)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h := newHashingReader(bytes.NewReader(object), "", int64(len(object)))
+		for {
+			if _, err := h.Read(buf); errors.Is(err, io.EOF) {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"code.gitea.io/gitea/modules/lfs"
+	"code.gitea.io/gitea/modules/lfstransfer/transfer"
+)
+
+// SSH protocol argument keys used by the locking commands
+const (
+	argCursor = "cursor"
+	argLimit  = "limit"
+)
+
+var _ transfer.LockBackend = &giteaLockBackend{}
+
+// giteaLockBackend is an adapter between the git-lfs-transfer locking
+// commands and Gitea's internal HTTP LFS locks API.
+type giteaLockBackend struct {
+	backend *GiteaBackend
+	// refname scopes list (and therefore Range/FromPath) to the ref passed
+	// on the "list-lock --ref=..." command, if any.
+	refname string
+}
+
+// LockBackend implements transfer.Backend.
+func (g *GiteaBackend) LockBackend(args transfer.Args) transfer.LockBackend {
+	return &giteaLockBackend{backend: g, refname: args[argRefname]}
+}
+
+// Create implements transfer.LockBackend.
+func (l *giteaLockBackend) Create(path, refname string) (*transfer.Lock, error) {
+	reqBody := lfs.LFSLockRequest{Path: path}
+	if refname != "" {
+		reqBody.Ref = &lfs.Reference{Name: refname}
+	}
+	bodyBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	url := l.backend.server + "/locks"
+	req := newInternalRequest(l.backend.ctx, url, http.MethodPost, l.backend.jsonHeaders(), bytes.NewReader(bodyBytes))
+	resp, err := req.Response()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, statusCodeToErr(resp.StatusCode)
+	}
+	var respBody lfs.LFSLockResponse
+	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
+		return nil, err
+	}
+	return toTransferLock(&respBody.Lock), nil
+}
+
+// Unlock implements transfer.LockBackend.
+func (l *giteaLockBackend) Unlock(lock *transfer.Lock, force bool) (*transfer.Lock, error) {
+	reqBody := lfs.LFSLockDeleteRequest{Force: force}
+	bodyBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	unlockURL := l.backend.server + "/locks/" + url.PathEscape(lock.Id) + "/unlock"
+	req := newInternalRequest(l.backend.ctx, unlockURL, http.MethodPost, l.backend.jsonHeaders(), bytes.NewReader(bodyBytes))
+	resp, err := req.Response()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusCodeToErr(resp.StatusCode)
+	}
+	var respBody lfs.LFSLockResponse
+	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
+		return nil, err
+	}
+	return toTransferLock(&respBody.Lock), nil
+}
+
+// FromPath implements transfer.LockBackend. It keeps following list's Next
+// cursor until path is found or the listing is exhausted, rather than
+// checking only the first page.
+func (l *giteaLockBackend) FromPath(path string) (*transfer.Lock, error) {
+	var found *transfer.Lock
+	cursor := ""
+	for {
+		next, err := l.list(cursor, 0, l.refname, func(lock *transfer.Lock) bool {
+			if lock.Path == path {
+				found = lock
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found != nil || next == "" {
+			return found, nil
+		}
+		cursor = next
+	}
+}
+
+// FromID implements transfer.LockBackend.
+func (l *giteaLockBackend) FromID(id string) (*transfer.Lock, error) {
+	lockURL := l.backend.server + "/locks?" + url.Values{"id": {id}}.Encode()
+	req := newInternalRequest(l.backend.ctx, lockURL, http.MethodGet, l.backend.jsonHeaders(), nil)
+	resp, err := req.Response()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusCodeToErr(resp.StatusCode)
+	}
+	var respBody lfs.LFSLockList
+	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
+		return nil, err
+	}
+	if len(respBody.Locks) == 0 {
+		return nil, transfer.ErrNotFound
+	}
+	return toTransferLock(respBody.Locks[0]), nil
+}
+
+// Range implements transfer.LockBackend, iterating over all locks (optionally
+// scoped to a ref) a page at a time using the same cursor/limit pagination as
+// the HTTP locks API.
+func (l *giteaLockBackend) Range(cursor string, limit int, iter func(*transfer.Lock) error) (string, error) {
+	return l.list(cursor, limit, l.refname, func(lock *transfer.Lock) bool {
+		return iter(lock) == nil
+	})
+}
+
+// list fetches a single page of locks from the HTTP API, invoking fn for
+// each lock on it until fn returns false, and returns the next cursor (empty
+// once exhausted, or when fn stopped early). Following the cursor to later
+// pages is up to the caller.
+func (l *giteaLockBackend) list(cursor string, limit int, refname string, fn func(*transfer.Lock) bool) (string, error) {
+	locksURL := l.backend.server + "/locks"
+	query := url.Values{}
+	if cursor != "" {
+		query.Set(argCursor, cursor)
+	}
+	if limit > 0 {
+		query.Set(argLimit, strconv.Itoa(limit))
+	}
+	if refname != "" {
+		query.Set(argRefname, refname)
+	}
+	if len(query) > 0 {
+		locksURL += "?" + query.Encode()
+	}
+	req := newInternalRequest(l.backend.ctx, locksURL, http.MethodGet, l.backend.jsonHeaders(), nil)
+	resp, err := req.Response()
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusCodeToErr(resp.StatusCode)
+	}
+	var respBody lfs.LFSLockList
+	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
+		return "", err
+	}
+	for _, lock := range respBody.Locks {
+		if !fn(toTransferLock(lock)) {
+			return "", nil
+		}
+	}
+	return respBody.Next, nil
+}
+
+func toTransferLock(lock *lfs.LFSLock) *transfer.Lock {
+	return &transfer.Lock{
+		Id:       strconv.FormatInt(lock.ID, 10),
+		Path:     lock.Path,
+		Name:     lock.Owner.Name,
+		Email:    lock.Owner.Email,
+		LockedAt: lock.LockedAt,
+	}
+}
+
+// jsonHeaders returns the common Authorization/Accept/Content-Type headers
+// used by the locking endpoints, which all speak the git-lfs+json API.
+func (g *GiteaBackend) jsonHeaders() map[string]string {
+	return map[string]string{
+		headerAuthorisation: g.token,
+		headerAccept:        mimeGitLFS,
+		headerContentType:   mimeGitLFS,
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// This file drives giteaLockBackend end to end against a fake HTTP locks
+// API - Create/Unlock/Range/FromPath/FromID down to the query string/path
+// each one builds. It does not drive the real git-lfs-transfer client over
+// SSH/pktline, so a wiring bug in LockBackend's capability advertisement or
+// in Main's pktline plumbing itself would not be caught here.
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/modules/lfstransfer/transfer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLockOwner and fakeLock mirror the wire shape of Gitea's internal LFS
+// locks HTTP API well enough to drive giteaLockBackend end to end; they are
+// kept independent of modules/lfs's own Go types so the test only depends on
+// the JSON contract, not on that package's internal layout.
+type fakeLockOwner struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type fakeLock struct {
+	ID       int64         `json:"id"`
+	Path     string        `json:"path"`
+	Owner    fakeLockOwner `json:"owner"`
+	LockedAt string        `json:"locked_at"`
+}
+
+// fakeLocksServer is a minimal in-memory stand-in for Gitea's internal LFS
+// locks HTTP API.
+type fakeLocksServer struct {
+	t      *testing.T
+	locks  []fakeLock
+	nextID int64
+
+	lastListRawQuery string
+	lastUnlockPath   string
+
+	// pageSize, when > 0, caps how many locks a single GET /locks call
+	// returns, forcing callers to page through via the cursor/Next protocol
+	// instead of getting everything back in one response.
+	pageSize int
+}
+
+func newFakeLocksServer(t *testing.T) (*httptest.Server, *fakeLocksServer) {
+	f := &fakeLocksServer{t: t, nextID: 1}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/locks/", func(w http.ResponseWriter, r *http.Request) {
+		f.lastUnlockPath = r.URL.Path
+		// .../locks/{id}/unlock
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/locks/"), "/unlock")
+		idx := f.indexOf(id)
+		if idx < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		lock := f.locks[idx]
+		f.locks = append(f.locks[:idx], f.locks[idx+1:]...)
+		writeJSON(w, http.StatusOK, map[string]any{"lock": lock})
+	})
+	mux.HandleFunc("/locks", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var req struct {
+				Path string `json:"path"`
+				Ref  *struct {
+					Name string `json:"name"`
+				} `json:"ref"`
+			}
+			require.NoError(f.t, json.NewDecoder(r.Body).Decode(&req))
+			lock := fakeLock{ID: f.nextID, Path: req.Path, Owner: fakeLockOwner{Name: "gitea", Email: "gitea@example.com"}, LockedAt: "2024-01-01T00:00:00Z"}
+			f.nextID++
+			f.locks = append(f.locks, lock)
+			writeJSON(w, http.StatusCreated, map[string]any{"lock": lock})
+		case http.MethodGet:
+			f.lastListRawQuery = r.URL.RawQuery
+			q := r.URL.Query()
+			if id := q.Get("id"); id != "" {
+				if idx := f.indexOf(id); idx >= 0 {
+					writeJSON(w, http.StatusOK, map[string]any{"locks": []fakeLock{f.locks[idx]}})
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]any{"locks": []fakeLock{}})
+				return
+			}
+			refname := q.Get(argRefname)
+			var filtered []fakeLock
+			for _, l := range f.locks {
+				if refname == "" || refname == "refs/heads/"+l.Path {
+					filtered = append(filtered, l)
+				}
+			}
+			if f.pageSize <= 0 {
+				writeJSON(w, http.StatusOK, map[string]any{"locks": filtered})
+				return
+			}
+			offset, _ := strconv.Atoi(q.Get(argCursor))
+			end := offset + f.pageSize
+			var next string
+			if end < len(filtered) {
+				next = strconv.Itoa(end)
+			} else {
+				end = len(filtered)
+			}
+			if offset > len(filtered) {
+				offset = len(filtered)
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"locks": filtered[offset:end], "next": next})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	return srv, f
+}
+
+func (f *fakeLocksServer) indexOf(id string) int {
+	for i, l := range f.locks {
+		if strconv.FormatInt(l.ID, 10) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set(headerContentType, mimeGitLFS)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestLockBackend(srv *httptest.Server, args transfer.Args) *giteaLockBackend {
+	g := &GiteaBackend{ctx: context.Background(), server: srv.URL, token: "test-token"}
+	return g.LockBackend(args).(*giteaLockBackend)
+}
+
+func TestLockBackend_CreateAndFromID(t *testing.T) {
+	srv, _ := newFakeLocksServer(t)
+	defer srv.Close()
+
+	l := newTestLockBackend(srv, transfer.Args{})
+	lock, err := l.Create("foo/bar.bin", "")
+	require.NoError(t, err)
+	assert.Equal(t, "foo/bar.bin", lock.Path)
+	assert.Equal(t, "gitea", lock.Name)
+	assert.Equal(t, "gitea@example.com", lock.Email)
+
+	found, err := l.FromID(lock.Id)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, lock.Path, found.Path)
+}
+
+func TestLockBackend_FromPath(t *testing.T) {
+	srv, _ := newFakeLocksServer(t)
+	defer srv.Close()
+
+	l := newTestLockBackend(srv, transfer.Args{})
+	_, err := l.Create("foo/bar.bin", "")
+	require.NoError(t, err)
+	_, err = l.Create("baz.bin", "")
+	require.NoError(t, err)
+
+	found, err := l.FromPath("baz.bin")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "baz.bin", found.Path)
+
+	missing, err := l.FromPath("nope.bin")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+// TestLockBackend_FromPathFollowsPagination is a regression test for
+// FromPath stopping after the first page of list: a lock past page one must
+// still be found by following Next until it turns up or the listing is
+// exhausted.
+func TestLockBackend_FromPathFollowsPagination(t *testing.T) {
+	srv, f := newFakeLocksServer(t)
+	defer srv.Close()
+	f.pageSize = 1
+
+	l := newTestLockBackend(srv, transfer.Args{})
+	for _, p := range []string{"a.bin", "b.bin", "c.bin"} {
+		_, err := l.Create(p, "")
+		require.NoError(t, err)
+	}
+
+	found, err := l.FromPath("c.bin")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "c.bin", found.Path)
+
+	missing, err := l.FromPath("nope.bin")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestLockBackend_UnlockRemovesLock(t *testing.T) {
+	srv, _ := newFakeLocksServer(t)
+	defer srv.Close()
+
+	l := newTestLockBackend(srv, transfer.Args{})
+	lock, err := l.Create("foo/bar.bin", "")
+	require.NoError(t, err)
+
+	unlocked, err := l.Unlock(lock, false)
+	require.NoError(t, err)
+	assert.Equal(t, lock.Id, unlocked.Id)
+
+	_, err = l.FromID(lock.Id)
+	assert.Error(t, err)
+}
+
+// TestLockBackend_UnlockEscapesID is a regression test for Unlock building
+// its URL by raw concatenation instead of url.PathEscape: a lock id
+// containing bytes that are special in a URL path (here "/" and "?") must
+// still reach the server as a single path segment, rather than being split
+// into extra path segments or bleeding into a query string.
+func TestLockBackend_UnlockEscapesID(t *testing.T) {
+	srv, f := newFakeLocksServer(t)
+	defer srv.Close()
+
+	const id = "123/456?evil=1"
+	l := newTestLockBackend(srv, transfer.Args{})
+	_, err := l.Unlock(&transfer.Lock{Id: id}, false)
+	assert.Error(t, err, "id doesn't exist, so unlock should fail, but must still reach the server intact")
+	assert.Equal(t, "/locks/"+id+"/unlock", f.lastUnlockPath, "the escaped id must decode back to a single intact path segment")
+}
+
+func TestLockBackend_RangeIteratesAllLocks(t *testing.T) {
+	srv, _ := newFakeLocksServer(t)
+	defer srv.Close()
+
+	l := newTestLockBackend(srv, transfer.Args{})
+	for _, p := range []string{"a.bin", "b.bin", "c.bin"} {
+		_, err := l.Create(p, "")
+		require.NoError(t, err)
+	}
+
+	var seen []string
+	_, err := l.Range("", 0, func(lock *transfer.Lock) error {
+		seen = append(seen, lock.Path)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.bin", "b.bin", "c.bin"}, seen)
+}
+
+// TestLockBackend_ListEscapesRefname is a regression test for building the
+// locks query string by raw concatenation instead of net/url.Values.Encode:
+// a refname containing bytes that are legal in a git ref (here "&" and a
+// space) must still reach the server as a single, correctly scoped refname
+// param, rather than corrupting the query string or truncating at the "&".
+func TestLockBackend_ListEscapesRefname(t *testing.T) {
+	srv, f := newFakeLocksServer(t)
+	defer srv.Close()
+
+	const refname = "refs/heads/feature&branch with spaces"
+	l := newTestLockBackend(srv, transfer.Args{argRefname: refname})
+	_, err := l.list("", 0, refname, func(*transfer.Lock) bool { return true })
+	require.NoError(t, err)
+
+	q, err := url.ParseQuery(f.lastListRawQuery)
+	require.NoError(t, err)
+	assert.Equal(t, refname, q.Get(argRefname))
+}
+
+// TestLockBackend_FromIDEscapesID is a regression test for FromID building
+// its URL by raw concatenation instead of net/url.Values.Encode: a lock id
+// containing bytes that are special in a query string (here "&" and a space)
+// must still reach the server as a single "id" value, rather than corrupting
+// the query string or being split into extra params.
+func TestLockBackend_FromIDEscapesID(t *testing.T) {
+	srv, f := newFakeLocksServer(t)
+	defer srv.Close()
+
+	const id = "123&evil=1 value"
+	l := newTestLockBackend(srv, transfer.Args{})
+	_, err := l.FromID(id)
+	assert.ErrorIs(t, err, transfer.ErrNotFound)
+
+	q, err := url.ParseQuery(f.lastListRawQuery)
+	require.NoError(t, err)
+	assert.Equal(t, id, q.Get("id"))
+	assert.Len(t, q, 1, "the crafted id must not be split into extra query params")
+}
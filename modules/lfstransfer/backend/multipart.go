@@ -0,0 +1,180 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/lfstransfer/transfer"
+)
+
+// multipartPart describes one presigned part of a multipart-basic upload, as
+// advertised in the batch response's upload action "parts" header. Size is
+// the exact byte length the store carved out for this part; stores are free
+// to chunk unevenly (e.g. fixed chunk size with a short last part), so it is
+// never inferred from object size and part count.
+type multipartPart struct {
+	PartNumber int               `json:"part_number"`
+	Href       string            `json:"href"`
+	Size       int64             `json:"size"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// completedPart is what the verify action expects back for each part once
+// its PUT has completed, so the store can assemble the object.
+type completedPart struct {
+	PartNumber int    `json:"part_number"`
+	Etag       string `json:"etag"`
+}
+
+// multipartUpload implements the LFS custom-transfer "multipart-basic"
+// adapter: the object is split across the presigned parts returned by the
+// batch response and each part is streamed through a bounded pipe into its
+// own PUT, then the assembled ETags are POSTed to the verify action so the
+// store can complete the upload. Like Upload, the bytes are hashed as they
+// stream through so a corrupted or truncated object is never confirmed to
+// storage, even though it crosses multiple PUTs instead of one.
+//
+// Parts are read off the shared pktline stream sequentially, one at a time:
+// the stream can only be read once and in order, so sending every part's
+// body at the same time would require buffering every part in memory first,
+// which is the exact whole-object-in-RAM cost streaming this adapter was
+// introduced to avoid. Each part's PUT runs on its own goroutine, and the
+// next part's PUT starts as soon as the previous part's bytes have been
+// written, without waiting for its response; so several PUTs can be in
+// flight at once, but only one of them is ever sending its body.
+func (g *GiteaBackend) multipartUpload(oid string, size int64, r io.Reader, partsJSON, verifyURL string) error {
+	var parts []multipartPart
+	if err := json.Unmarshal([]byte(partsJSON), &parts); err != nil {
+		return fmt.Errorf("invalid parts header for object %s: %w", oid, err)
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%w: object %s has no multipart parts", transfer.ErrParseError, oid)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+
+	var partsTotal int64
+	for _, part := range parts {
+		if part.Size <= 0 {
+			return fmt.Errorf("%w: object %s part %d has no size", transfer.ErrParseError, oid, part.PartNumber)
+		}
+		partsTotal += part.Size
+	}
+	if partsTotal != size {
+		return fmt.Errorf("%w: object %s parts sizes sum to %d, want %d", transfer.ErrParseError, oid, partsTotal, size)
+	}
+
+	hashed := newHashingReader(r, oid, size)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		firstErr  error
+		completed = make([]completedPart, len(parts))
+	)
+	// hashed wraps a single, non-concurrency-safe pktline stream, so each
+	// part's bytes are only ever read off it here, in order, one part at a
+	// time. Only the PUT itself - draining the pipe on the other end - runs
+	// concurrently with that read, which overlaps a part's upload with
+	// reading the next part instead of buffering the whole object in RAM.
+	for i, part := range parts {
+		pr, pw := io.Pipe()
+		wg.Add(1)
+		go func(i int, part multipartPart) {
+			defer wg.Done()
+			etag, err := g.uploadPart(part, pr, part.Size)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("uploading part %d of object %s: %w", part.PartNumber, oid, err)
+				}
+				return
+			}
+			completed[i] = completedPart{PartNumber: part.PartNumber, Etag: etag}
+		}(i, part)
+
+		_, copyErr := io.CopyBuffer(pw, io.LimitReader(hashed, part.Size), make([]byte, uploadChunkSize))
+		pw.CloseWithError(copyErr)
+		if copyErr != nil {
+			wg.Wait()
+			return fmt.Errorf("reading part %d of object %s: %w", part.PartNumber, oid, copyErr)
+		}
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := hashed.verify(); err != nil {
+		return err
+	}
+
+	if err := g.verifyMultipart(verifyURL, oid, size, completed); err != nil {
+		return err
+	}
+	g.markVerified(oid)
+	return nil
+}
+
+// uploadPart PUTs a single part to its presigned href and returns the ETag
+// the store assigned it.
+func (g *GiteaBackend) uploadPart(part multipartPart, body io.Reader, size int64) (string, error) {
+	headers := make(map[string]string, len(part.Headers)+1)
+	for k, v := range part.Headers {
+		headers[k] = v
+	}
+	headers[headerContentLength] = strconv.FormatInt(size, 10)
+
+	req := newInternalRequest(g.ctx, part.Href, http.MethodPut, headers, body).
+		SetTimeout(10*time.Second, uploadTimeout(size))
+	resp, err := req.Response()
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusCodeToErr(resp.StatusCode)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// verifyMultipart posts the assembled part list to the verify action so the
+// store can confirm and complete the multipart upload.
+func (g *GiteaBackend) verifyMultipart(verifyURL, oid string, size int64, parts []completedPart) error {
+	if verifyURL == "" {
+		return fmt.Errorf("%w: object %s has no verify action for multipart upload", transfer.ErrParseError, oid)
+	}
+	reqBody := struct {
+		Oid   string          `json:"oid"`
+		Size  int64           `json:"size"`
+		Parts []completedPart `json:"parts"`
+	}{Oid: oid, Size: size, Parts: parts}
+	bodyBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		headerAuthorisation: g.token,
+		headerAccept:        mimeGitLFS,
+		headerContentType:   mimeGitLFS,
+	}
+	req := newInternalRequest(g.ctx, verifyURL, http.MethodPost, headers, bytes.NewReader(bodyBytes))
+	resp, err := req.Response()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusCodeToErr(resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// TestMultipartUpload_PartsNotInterleaved exercises multipartUpload against a
+// fake S3-style endpoint and asserts that every part receives exactly the
+// bytes of its own slice of the object, in order - regression test for the
+// part PUTs racing over the shared pktline reader.
+func TestMultipartUpload_PartsNotInterleaved(t *testing.T) {
+	const (
+		numParts = 4
+		partSize = 1024
+		size     = numParts * partSize
+	)
+
+	object := make([]byte, size)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	received := make([][]byte, numParts)
+
+	mux := http.NewServeMux()
+	var verifyBody []byte
+	for i := 0; i < numParts; i++ {
+		i := i
+		mux.HandleFunc(fmt.Sprintf("/part/%d", i+1), func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			mu.Lock()
+			received[i] = body
+			mu.Unlock()
+			w.Header().Set("ETag", fmt.Sprintf("etag-%d", i+1))
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		verifyBody, err = io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	parts := make([]multipartPart, numParts)
+	for i := 0; i < numParts; i++ {
+		parts[i] = multipartPart{PartNumber: i + 1, Href: srv.URL + fmt.Sprintf("/part/%d", i+1), Size: partSize}
+	}
+	partsJSON, err := json.Marshal(parts)
+	require.NoError(t, err)
+
+	oid := sha256Hex(object)
+	g := &GiteaBackend{ctx: context.Background(), token: "test-token", verified: make(map[string]struct{})}
+	err = g.multipartUpload(oid, int64(size), bytes.NewReader(object), string(partsJSON), srv.URL+"/verify")
+	require.NoError(t, err)
+
+	for i := 0; i < numParts; i++ {
+		want := object[i*partSize : (i+1)*partSize]
+		assert.Equal(t, want, received[i], "part %d received the wrong slice of the object", i+1)
+	}
+
+	var verifyReq struct {
+		Oid   string `json:"oid"`
+		Size  int64  `json:"size"`
+		Parts []completedPart
+	}
+	require.NoError(t, json.Unmarshal(verifyBody, &verifyReq))
+	assert.Equal(t, oid, verifyReq.Oid)
+	assert.Equal(t, int64(size), verifyReq.Size)
+	assert.Len(t, verifyReq.Parts, numParts)
+}
+
+// TestMultipartUpload_UnevenPartSizes exercises the common real-world
+// convention of a fixed chunk size with a short final part, which an
+// even size/len(parts) split would carve up wrong - regression test for
+// trusting the parts header's own Size field instead of assuming an equal
+// division of the object.
+func TestMultipartUpload_UnevenPartSizes(t *testing.T) {
+	partSizes := []int64{2000, 2000, 47}
+	size := int64(0)
+	for _, n := range partSizes {
+		size += n
+	}
+
+	object := make([]byte, size)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	received := make([][]byte, len(partSizes))
+
+	mux := http.NewServeMux()
+	for i := range partSizes {
+		i := i
+		mux.HandleFunc(fmt.Sprintf("/part/%d", i+1), func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			mu.Lock()
+			received[i] = body
+			mu.Unlock()
+			w.Header().Set("ETag", fmt.Sprintf("etag-%d", i+1))
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	parts := make([]multipartPart, len(partSizes))
+	for i, n := range partSizes {
+		parts[i] = multipartPart{PartNumber: i + 1, Href: srv.URL + fmt.Sprintf("/part/%d", i+1), Size: n}
+	}
+	partsJSON, err := json.Marshal(parts)
+	require.NoError(t, err)
+
+	oid := sha256Hex(object)
+	g := &GiteaBackend{ctx: context.Background(), token: "test-token", verified: make(map[string]struct{})}
+	err = g.multipartUpload(oid, size, bytes.NewReader(object), string(partsJSON), srv.URL+"/verify")
+	require.NoError(t, err)
+
+	offset := int64(0)
+	for i, n := range partSizes {
+		want := object[offset : offset+n]
+		assert.Equal(t, want, received[i], "part %d received the wrong slice of the object", i+1)
+		offset += n
+	}
+}
+
+// TestMultipartUpload_PartSizeMismatch asserts that a parts header whose
+// sizes don't add up to the object's advertised size is rejected outright
+// rather than silently uploading the wrong bytes to the wrong parts.
+func TestMultipartUpload_PartSizeMismatch(t *testing.T) {
+	parts := []multipartPart{
+		{PartNumber: 1, Href: "http://example.invalid/part/1", Size: 10},
+		{PartNumber: 2, Href: "http://example.invalid/part/2", Size: 10},
+	}
+	partsJSON, err := json.Marshal(parts)
+	require.NoError(t, err)
+
+	g := &GiteaBackend{ctx: context.Background(), token: "test-token"}
+	err = g.multipartUpload("deadbeef", 21, bytes.NewReader(make([]byte, 21)), string(partsJSON), "http://example.invalid/verify")
+	require.Error(t, err)
+}
+
+// TestMultipartUpload_RejectsCorruptObject is a regression test for the
+// multipart adapter never hashing the object at all: every part PUTs
+// successfully, but the bytes don't match the oid the transfer started with,
+// so the upload must fail and the verify action must never be called.
+func TestMultipartUpload_RejectsCorruptObject(t *testing.T) {
+	const (
+		numParts = 2
+		partSize = 1024
+		size     = numParts * partSize
+	)
+
+	object := make([]byte, size)
+	_, err := rand.Read(object)
+	require.NoError(t, err)
+	wrongOid := sha256Hex(make([]byte, size)) // does not match object's real hash
+
+	verifyCalled := false
+	mux := http.NewServeMux()
+	for i := 0; i < numParts; i++ {
+		i := i
+		mux.HandleFunc(fmt.Sprintf("/part/%d", i+1), func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			w.Header().Set("ETag", fmt.Sprintf("etag-%d", i+1))
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		verifyCalled = true
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	parts := make([]multipartPart, numParts)
+	for i := 0; i < numParts; i++ {
+		parts[i] = multipartPart{PartNumber: i + 1, Href: srv.URL + fmt.Sprintf("/part/%d", i+1), Size: partSize}
+	}
+	partsJSON, err := json.Marshal(parts)
+	require.NoError(t, err)
+
+	g := &GiteaBackend{ctx: context.Background(), token: "test-token"}
+	err = g.multipartUpload(wrongOid, int64(size), bytes.NewReader(object), string(partsJSON), srv.URL+"/verify")
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.False(t, verifyCalled, "a corrupt object must never reach the verify action")
+}
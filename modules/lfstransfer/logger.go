@@ -6,23 +6,73 @@ package lfstransfer
 import (
 	"fmt"
 	"io"
+	"strings"
 
+	"code.gitea.io/gitea/modules/log"
 	"github.com/charmbracelet/git-lfs-transfer/transfer"
 )
 
 var _ transfer.Logger = (*GiteaLogger)(nil)
 
-// noop logger for passing into transfer
+// redactedArgKeys lists the protocol argument/header names whose values must
+// never reach the log, since they carry bearer tokens.
+var redactedArgKeys = map[string]struct{}{
+	"token":         {},
+	"Authorization": {},
+}
+
+const redacted = "<redacted>"
+
+// GiteaLogger forwards git-lfs-transfer protocol events to Gitea's standard
+// logger instead of writing directly to a file, redacting any token or
+// Authorization values it is handed along the way. When trace is non-nil
+// (set up when [lfs].LOG_PROTOCOL_TRACE is enabled), the same already-redacted
+// line is also appended to it - the raw pktline stream itself is never
+// written anywhere, since it can carry the same tokens.
 type GiteaLogger struct {
-	p string
-	w io.Writer
+	prefix string
+	trace  io.Writer
 }
 
-func newLogger(p string, w io.Writer) transfer.Logger {
-	return &GiteaLogger{p: p + ": ", w: w}
+func newLogger(prefix string, trace io.Writer) transfer.Logger {
+	return &GiteaLogger{prefix: prefix, trace: trace}
 }
 
 // Log implements transfer.Logger
-func (g *GiteaLogger) Log(msg string, itms ...interface{}) {
-	fmt.Fprintln(g.w, g.p, msg, itms)
+func (g *GiteaLogger) Log(msg string, items ...interface{}) {
+	redactedItems := redactItems(items)
+	log.GetLogger("lfs").Debug("%s %s %s", g.prefix, msg, redactedItems)
+	if g.trace != nil {
+		fmt.Fprintf(g.trace, "%s %s %s\n", g.prefix, msg, redactedItems)
+	}
+}
+
+// redactItems renders the variadic fields passed to Log, redacting any
+// "token"/"Authorization" entries found in the arg/header maps the transfer
+// library passes in - which arrive as transfer.Args rather than a bare
+// map[string]string, even though the two share an underlying type.
+func redactItems(items []interface{}) string {
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case transfer.Args:
+			parts = append(parts, redactArgs(map[string]string(v)))
+		case map[string]string:
+			parts = append(parts, redactArgs(v))
+		default:
+			parts = append(parts, fmt.Sprint(item))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func redactArgs(args map[string]string) string {
+	redactedArgs := make(map[string]string, len(args))
+	for k, v := range args {
+		if _, sensitive := redactedArgKeys[k]; sensitive {
+			v = redacted
+		}
+		redactedArgs[k] = v
+	}
+	return fmt.Sprintf("%v", redactedArgs)
 }
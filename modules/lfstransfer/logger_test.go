@@ -0,0 +1,45 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package lfstransfer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/charmbracelet/git-lfs-transfer/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGiteaLogger_RedactsTransferArgs is a regression test for Log being
+// called with the named transfer.Args type the git-lfs-transfer library
+// actually uses, rather than a bare map[string]string.
+func TestGiteaLogger_RedactsTransferArgs(t *testing.T) {
+	var trace bytes.Buffer
+	logger := newLogger("PID [00001]", &trace)
+
+	logger.Log("batch", transfer.Args{
+		"token": "super-secret-token",
+		"id":    "abc123",
+	})
+
+	out := trace.String()
+	assert.NotContains(t, out, "super-secret-token")
+	assert.Contains(t, out, redacted)
+	assert.Contains(t, out, "abc123")
+}
+
+// TestGiteaLogger_RedactsAuthorizationHeader covers the map[string]string
+// shape (e.g. response headers), in addition to transfer.Args.
+func TestGiteaLogger_RedactsAuthorizationHeader(t *testing.T) {
+	var trace bytes.Buffer
+	logger := newLogger("PID [00001]", &trace)
+
+	logger.Log("download", map[string]string{
+		"Authorization": "Bearer super-secret-token",
+	})
+
+	out := trace.String()
+	assert.NotContains(t, out, "super-secret-token")
+	assert.Contains(t, out, redacted)
+}
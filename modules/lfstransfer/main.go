@@ -8,21 +8,31 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 
 	"code.gitea.io/gitea/modules/lfstransfer/backend"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
 	"github.com/charmbracelet/git-lfs-transfer/transfer"
 )
 
 func Main(ctx context.Context, repo string, verb string, token string) error {
-	f, _ := os.OpenFile("/tmp/lfs.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	defer f.Close()
-	logger := newLogger(fmt.Sprintf("PID [%05d]", os.Getpid()), f)
-	t, _ := os.OpenFile(fmt.Sprintf("/tmp/lfs/%v.log", os.Getpid()), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	defer t.Close()
-	in := io.TeeReader(os.Stdin, t)
-	out := io.MultiWriter(os.Stdout, t)
-	pktline := transfer.NewPktline(in, out, logger)
+	var trace io.Writer
+	if setting.LFS.LogProtocolTrace {
+		if traceFile, err := openProtocolTraceFile(); err != nil {
+			log.GetLogger("lfs").Warn("could not open protocol trace file: %v", err)
+		} else {
+			defer traceFile.Close()
+			trace = traceFile
+		}
+	}
+	logger := newLogger(fmt.Sprintf("PID [%05d]", os.Getpid()), trace)
+
+	// The raw pktline stream is never tee'd anywhere - it can carry the same
+	// tokens the logger redacts, so tracing only ever happens through logger's
+	// already-redacted Log calls above.
+	pktline := transfer.NewPktline(os.Stdin, os.Stdout, logger)
 	giteaBackend, err := backend.New(ctx, repo, verb, token, logger)
 	if err != nil {
 		return err
@@ -55,3 +65,17 @@ func Main(ctx context.Context, repo string, verb string, token string) error {
 		return fmt.Errorf("unknown operation %q", verb)
 	}
 }
+
+// openProtocolTraceFile opens the per-session pktline trace file under the
+// configured log root, named by pid so concurrent SSH sessions don't clobber
+// each other's trace; a file already there under that name is appended to.
+// It only ever receives the logger's redacted
+// output, never the raw wire bytes.
+func openProtocolTraceFile() (*os.File, error) {
+	dir := filepath.Join(setting.Log.RootPath, "lfs")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("protocol-trace-%d.log", os.Getpid()))
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+}
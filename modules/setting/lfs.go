@@ -0,0 +1,25 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import "gopkg.in/ini.v1"
+
+// LFS holds the configuration for Gitea's Git LFS support.
+var LFS = struct {
+	// MaxFileSize caps the size of a single LFS object Gitea will accept
+	// over any transport; <= 0 means unlimited.
+	MaxFileSize int64
+	// LogProtocolTrace enables a pktline-level trace of the SSH LFS
+	// transfer protocol to a per-session file under [log].ROOT_PATH. It is
+	// off by default because the trace can include request headers.
+	LogProtocolTrace bool
+}{}
+
+// loadLFSFrom reads the [lfs] section into LFS. It is called from the main
+// settings loader alongside the other loadXxxFrom functions.
+func loadLFSFrom(rootCfg *ini.File) {
+	sec := rootCfg.Section("lfs")
+	LFS.MaxFileSize = sec.Key("MAX_FILE_SIZE").MustInt64(0)
+	LFS.LogProtocolTrace = sec.Key("LOG_PROTOCOL_TRACE").MustBool(false)
+}
@@ -0,0 +1,24 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import "gopkg.in/ini.v1"
+
+// LFSClient holds configuration for the internal HTTP client the SSH LFS
+// transfer backend uses to call back into Gitea's own LFS HTTP API.
+var LFSClient = struct {
+	// InsecureSkipVerify disables TLS certificate validation when LocalURL
+	// points somewhere other than the loopback interface or a unix socket.
+	// It defaults to off; it exists only as an explicit escape hatch for a
+	// misconfigured environment, never as a default posture.
+	InsecureSkipVerify bool
+}{}
+
+// loadLFSClientFrom reads the [lfs_client] section into LFSClient. It is
+// called from the main settings loader alongside the other loadXxxFrom
+// functions.
+func loadLFSClientFrom(rootCfg *ini.File) {
+	sec := rootCfg.Section("lfs_client")
+	LFSClient.InsecureSkipVerify = sec.Key("INSECURE_SKIP_VERIFY").MustBool(false)
+}
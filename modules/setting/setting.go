@@ -0,0 +1,16 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import "gopkg.in/ini.v1"
+
+// loadCommonSettingsFrom loads the configuration sections shared across
+// Gitea's entry points. The LFS SSH transfer backend only cares about the
+// two sections wired in here; the rest of this function's real section
+// loaders live alongside their own settings (log, server, database, ...)
+// and are omitted from this snapshot.
+func loadCommonSettingsFrom(rootCfg *ini.File) {
+	loadLFSFrom(rootCfg)
+	loadLFSClientFrom(rootCfg)
+}